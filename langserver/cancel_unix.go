@@ -0,0 +1,13 @@
+//go:build !windows
+
+package langserver
+
+import (
+	"os"
+	"syscall"
+)
+
+// terminate asks p to exit gracefully via SIGTERM.
+func terminate(p *os.Process) {
+	p.Signal(syscall.SIGTERM)
+}
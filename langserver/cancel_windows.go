@@ -0,0 +1,10 @@
+//go:build windows
+
+package langserver
+
+import "os"
+
+// terminate kills p. Windows has no SIGTERM equivalent, so this is a hard kill.
+func terminate(p *os.Process) {
+	p.Kill()
+}
@@ -0,0 +1,70 @@
+package langserver
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// InitializeParams are the parameters sent in an "initialize" request.
+type InitializeParams struct {
+	RootPath         string            `json:"rootPath,omitempty"`
+	RootURI          string            `json:"rootUri,omitempty"`
+	WorkspaceFolders []WorkspaceFolder `json:"workspaceFolders,omitempty"`
+}
+
+// InitializeResult is the result of an "initialize" request.
+type InitializeResult struct {
+	Capabilities ServerCapabilities `json:"capabilities"`
+}
+
+// ServerCapabilities advertises what this language server supports.
+type ServerCapabilities struct {
+	TextDocumentSync           int  `json:"textDocumentSync"`
+	DocumentFormattingProvider bool `json:"documentFormattingProvider"`
+}
+
+const (
+	// TDSKFull means the server expects the full text of the document on every change.
+	TDSKFull = 1
+)
+
+// initialWorkspaceFolders returns the workspace folders to seed the handler
+// with at startup. Clients that predate the multi-root workspaceFolders
+// capability (or simply don't use it) instead send the legacy rootUri or
+// rootPath fields, so those are used as a single implicit workspace folder
+// when the client reported no workspaceFolders of its own.
+func initialWorkspaceFolders(params InitializeParams) []WorkspaceFolder {
+	if len(params.WorkspaceFolders) > 0 {
+		return params.WorkspaceFolders
+	}
+
+	uri := params.RootURI
+	if uri == "" && params.RootPath != "" {
+		uri = toURI(params.RootPath).String()
+	}
+	if uri == "" {
+		return nil
+	}
+	return []WorkspaceFolder{{URI: uri}}
+}
+
+func (h *langHandler) handleInitialize(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result interface{}, err error) {
+	h.conn = conn
+
+	if req.Params != nil {
+		var params InitializeParams
+		if err := json.Unmarshal(*req.Params, &params); err != nil {
+			return nil, err
+		}
+		h.setWorkspaceFolders(initialWorkspaceFolders(params))
+	}
+
+	return InitializeResult{
+		Capabilities: ServerCapabilities{
+			TextDocumentSync:           TDSKFull,
+			DocumentFormattingProvider: true,
+		},
+	}, nil
+}
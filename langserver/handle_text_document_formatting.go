@@ -0,0 +1,221 @@
+package langserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// DocumentFormattingParams are the parameters of a textDocument/formatting request.
+type DocumentFormattingParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Options      FormattingOptions      `json:"options"`
+}
+
+// TextDocumentIdentifier identifies a text document by URI.
+type TextDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+// FormattingOptions are the formatting options defined by the LSP spec.
+type FormattingOptions struct {
+	TabSize      int  `json:"tabSize"`
+	InsertSpaces bool `json:"insertSpaces"`
+}
+
+// TextEdit represents a textual change to a single text document.
+type TextEdit struct {
+	Range   Range  `json:"range"`
+	NewText string `json:"newText"`
+}
+
+// ShowMessageParams are the parameters of a window/showMessage notification.
+type ShowMessageParams struct {
+	Type    int    `json:"type"`
+	Message string `json:"message"`
+}
+
+const (
+	// MTError is the message type for an error shown via window/showMessage.
+	MTError = 1
+)
+
+func (h *langHandler) handleTextDocumentFormatting(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result interface{}, err error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params DocumentFormattingParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+
+	f, ok := h.files[params.TextDocument.URI]
+	if !ok {
+		return nil, fmt.Errorf("document not found: %v", params.TextDocument.URI)
+	}
+
+	fname, err := fromURI(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	config := h.configFor(params.TextDocument.URI)
+	commands := config.FormatCommands
+	if len(commands) == 0 && config.FormatCommand != "" {
+		commands = []Config{config}
+	}
+	if len(commands) == 0 {
+		return nil, nil
+	}
+
+	limit := h.workspaceFolderFor(fname)
+	text := f.Text
+	for _, c := range commands {
+		out, err := runFormatCommand(c, fname, text, limit)
+		if err != nil {
+			h.conn.Notify(ctx, "window/showMessage", &ShowMessageParams{
+				Type:    MTError,
+				Message: fmt.Sprintf("efm-langserver: format command %q failed: %v", c.FormatCommand, err),
+			})
+			return nil, err
+		}
+		text = out
+	}
+
+	return diffToTextEdits(f.Text, text), nil
+}
+
+// runFormatCommand runs a single formatter in the pipeline, feeding it the
+// buffer either on stdin or through a temp file substituted into the command.
+func runFormatCommand(c Config, fname string, text string, limit string) (string, error) {
+	root := findRootPath(fname, c.RootMarkers, limit)
+	command := c.FormatCommand
+
+	var cmd *exec.Cmd
+	if c.FormatStdin {
+		command = substituteVars(command, root, fname)
+		if runtime.GOOS == "windows" {
+			cmd = exec.Command("cmd", "/c", command)
+		} else {
+			cmd = exec.Command("sh", "-c", command)
+		}
+		cmd.Stdin = strings.NewReader(text)
+	} else {
+		tmp, err := ioutil.TempFile("", "efm-format-*"+filepath.Ext(fname))
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(tmp.Name())
+		if _, err := tmp.WriteString(text); err != nil {
+			tmp.Close()
+			return "", err
+		}
+		if err := tmp.Close(); err != nil {
+			return "", err
+		}
+
+		command = substituteVars(command, root, tmp.Name())
+		if runtime.GOOS == "windows" {
+			cmd = exec.Command("cmd", "/c", command)
+		} else {
+			cmd = exec.Command("sh", "-c", command)
+		}
+	}
+	cmd.Dir = root
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// diffToTextEdits computes a line-based LCS diff between the original and
+// formatted buffer and returns the minimal set of TextEdits needed to turn
+// one into the other.
+func diffToTextEdits(original, formatted string) []TextEdit {
+	a := splitLines(original)
+	b := splitLines(formatted)
+	lcs := longestCommonSubsequence(a, b)
+
+	var edits []TextEdit
+	ai, bi := 0, 0
+	for _, idx := range lcs {
+		if idx[0] > ai || idx[1] > bi {
+			edits = append(edits, TextEdit{
+				Range: Range{
+					Start: Position{Line: ai, Character: 0},
+					End:   Position{Line: idx[0], Character: 0},
+				},
+				NewText: strings.Join(b[bi:idx[1]], ""),
+			})
+		}
+		ai, bi = idx[0]+1, idx[1]+1
+	}
+	if ai < len(a) || bi < len(b) {
+		edits = append(edits, TextEdit{
+			Range: Range{
+				Start: Position{Line: ai, Character: 0},
+				End:   Position{Line: len(a), Character: 0},
+			},
+			NewText: strings.Join(b[bi:], ""),
+		})
+	}
+	return edits
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// longestCommonSubsequence returns the indices, as [ai, bi] pairs, of lines
+// common to both a and b in order.
+func longestCommonSubsequence(a, b []string) [][2]int {
+	n, m := len(a), len(b)
+	dp := make([][]int, n+1)
+	for i := range dp {
+		dp[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				dp[i][j] = dp[i+1][j+1] + 1
+			} else if dp[i+1][j] >= dp[i][j+1] {
+				dp[i][j] = dp[i+1][j]
+			} else {
+				dp[i][j] = dp[i][j+1]
+			}
+		}
+	}
+
+	var pairs [][2]int
+	i, j := 0, 0
+	for i < n && j < m {
+		if a[i] == b[j] {
+			pairs = append(pairs, [2]int{i, j})
+			i++
+			j++
+		} else if dp[i+1][j] >= dp[i][j+1] {
+			i++
+		} else {
+			j++
+		}
+	}
+	return pairs
+}
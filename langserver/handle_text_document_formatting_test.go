@@ -0,0 +1,48 @@
+package langserver
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffToTextEdits(t *testing.T) {
+	original := "a\nb\nc\n"
+	formatted := "a\nx\nc\n"
+
+	got := diffToTextEdits(original, formatted)
+	want := []TextEdit{
+		{
+			Range: Range{
+				Start: Position{Line: 1, Character: 0},
+				End:   Position{Line: 2, Character: 0},
+			},
+			NewText: "x\n",
+		},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffToTextEdits() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDiffToTextEditsNoChange(t *testing.T) {
+	if got := diffToTextEdits("a\nb\n", "a\nb\n"); got != nil {
+		t.Errorf("diffToTextEdits() on identical buffers = %+v, want nil", got)
+	}
+}
+
+func TestDiffToTextEditsAppend(t *testing.T) {
+	got := diffToTextEdits("a\n", "a\nb\n")
+	want := []TextEdit{
+		{
+			Range: Range{
+				Start: Position{Line: 1, Character: 0},
+				End:   Position{Line: 1, Character: 0},
+			},
+			NewText: "b\n",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffToTextEdits() = %+v, want %+v", got, want)
+	}
+}
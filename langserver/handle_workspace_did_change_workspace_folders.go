@@ -0,0 +1,57 @@
+package langserver
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// WorkspaceFolder is a single root folder of a multi-root workspace.
+type WorkspaceFolder struct {
+	URI  string `json:"uri"`
+	Name string `json:"name"`
+}
+
+// WorkspaceFoldersChangeEvent describes folders added to and removed from a
+// multi-root workspace.
+type WorkspaceFoldersChangeEvent struct {
+	Added   []WorkspaceFolder `json:"added"`
+	Removed []WorkspaceFolder `json:"removed"`
+}
+
+// DidChangeWorkspaceFoldersParams are the parameters of a
+// workspace/didChangeWorkspaceFolders notification.
+type DidChangeWorkspaceFoldersParams struct {
+	Event WorkspaceFoldersChangeEvent `json:"event"`
+}
+
+func (h *langHandler) handleWorkspaceDidChangeWorkspaceFolders(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result interface{}, err error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params DidChangeWorkspaceFoldersParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+
+	h.workspaceFoldersMu.Lock()
+	defer h.workspaceFoldersMu.Unlock()
+
+	removed := make(map[string]bool, len(params.Event.Removed))
+	for _, f := range params.Event.Removed {
+		removed[f.URI] = true
+	}
+
+	folders := make([]WorkspaceFolder, 0, len(h.workspaceFolders)+len(params.Event.Added))
+	for _, f := range h.workspaceFolders {
+		if !removed[f.URI] {
+			folders = append(folders, f)
+		}
+	}
+	folders = append(folders, params.Event.Added...)
+	h.workspaceFolders = folders
+
+	return nil, nil
+}
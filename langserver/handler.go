@@ -1,6 +1,7 @@
 package langserver
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"net/url"
@@ -9,41 +10,98 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
 	"unicode"
 
 	"github.com/haya14busa/errorformat"
 	"github.com/sourcegraph/jsonrpc2"
+	"golang.org/x/sync/errgroup"
 )
 
+// lintDebounceDefault is how long the linter waits for a burst of didChange
+// events on the same URI to settle before actually running any lint
+// commands, when a Config doesn't set LintDebounce.
+const lintDebounceDefault = 250 * time.Millisecond
+
 type Config struct {
-	LintErrorFormats []string `yaml:"lint-error-formats"`
-	LintStdin        bool     `yaml:"lint-stdin"`
-	LintOffset       int      `yaml:"lint-offset"`
-	LintCommand      string   `yaml:"lint-command"`
+	LintErrorFormats []string       `yaml:"lint-error-formats"`
+	LintStdin        bool           `yaml:"lint-stdin"`
+	LintOffset       int            `yaml:"lint-offset"`
+	LintCommand      string         `yaml:"lint-command"`
+	LintSeverity     int            `yaml:"lint-severity"`
+	LintSource       string         `yaml:"lint-source"`
+	LintCategoryMap  map[string]int `yaml:"lint-category-map"`
+	LintDebounce     time.Duration  `yaml:"lint-debounce"`
+	LintAfterOpen    bool           `yaml:"lint-after-open"`
+	LintOnSave       bool           `yaml:"lint-on-save"`
+	RootMarkers      []string       `yaml:"root-markers"`
+	FormatCommand    string         `yaml:"format-command"`
+	FormatStdin      bool           `yaml:"format-stdin"`
+	FormatCommands   []Config       `yaml:"format-commands"`
+}
+
+// Configs is a list of lint/format configurations for a single languageId.
+// It unmarshals either a single mapping (the historical one-linter-per-
+// language schema) or a sequence of mappings, so existing config.yaml files
+// keep working unchanged.
+type Configs []Config
+
+func (cs *Configs) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var multi []Config
+	if err := unmarshal(&multi); err == nil {
+		*cs = multi
+		return nil
+	}
+
+	var single Config
+	if err := unmarshal(&single); err != nil {
+		return err
+	}
+	*cs = []Config{single}
+	return nil
 }
 
-func NewHandler(configs map[string]Config) jsonrpc2.Handler {
-	for _, v := range configs {
-		if v.LintErrorFormats == nil || len(v.LintErrorFormats) == -1 {
-			v.LintErrorFormats = []string{"%f:%l:%m", "%f:%l:%c:%m"}
+func NewHandler(configs map[string]Configs) jsonrpc2.Handler {
+	for _, cs := range configs {
+		for i := range cs {
+			if cs[i].LintErrorFormats == nil || len(cs[i].LintErrorFormats) == -1 {
+				cs[i].LintErrorFormats = []string{"%f:%l:%m", "%f:%l:%c:%m"}
+			}
 		}
 	}
-	// TODO Add formatCommand
 	var handler = &langHandler{
-		configs: configs,
-		files:   make(map[string]*File),
-		request: make(chan string),
-		conn:    nil,
+		configs:  configs,
+		files:    make(map[string]*File),
+		request:  make(chan string),
+		debounce: make(map[string]*time.Timer),
+		jobs:     make(map[string]*lintJob),
+		overlays: newOverlayCache(),
+		conn:     nil,
 	}
 	go handler.linter()
 	return jsonrpc2.HandlerWithError(handler.handle)
 }
 
 type langHandler struct {
-	configs map[string]Config
+	configs map[string]Configs
 	files   map[string]*File
 	request chan string
 	conn    *jsonrpc2.Conn
+
+	debounceMu sync.Mutex
+	debounce   map[string]*time.Timer
+
+	// jobs tracks the in-flight lint job for each URI, so a newer request
+	// can terminate a still-running older one instead of queueing behind
+	// it.
+	jobsMu sync.Mutex
+	jobs   map[string]*lintJob
+
+	overlays *overlayCache
+
+	workspaceFoldersMu sync.Mutex
+	workspaceFolders   []WorkspaceFolder
 }
 
 type File struct {
@@ -95,17 +153,54 @@ func (h *langHandler) linter() {
 		if !ok {
 			break
 		}
-		h.conn.Notify(
-			context.Background(),
-			"textDocument/publishDiagnostics",
-			&PublishDiagnosticsParams{
-				URI:         uri,
-				Diagnostics: h.lint(uri),
-			})
+		go h.runLint(uri)
+	}
+}
+
+// lintJob identifies a single in-flight call to lint(), so that it can be
+// told apart from whatever job has since replaced it for the same URI.
+type lintJob struct {
+	cancel context.CancelFunc
+}
+
+// runLint cancels any lint job already running for uri, then runs a new one
+// and publishes its diagnostics. If the job is itself cancelled by a later
+// runLint before it finishes, its (possibly partial) diagnostics are
+// discarded instead of being published.
+func (h *langHandler) runLint(uri string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	job := &lintJob{cancel: cancel}
+
+	h.jobsMu.Lock()
+	if prev, ok := h.jobs[uri]; ok {
+		prev.cancel()
+	}
+	h.jobs[uri] = job
+	h.jobsMu.Unlock()
+
+	diagnostics := h.lint(ctx, uri)
+
+	h.jobsMu.Lock()
+	if h.jobs[uri] == job {
+		delete(h.jobs, uri)
+	}
+	h.jobsMu.Unlock()
+
+	if ctx.Err() != nil {
+		return
 	}
+
+	h.conn.Notify(
+		context.Background(),
+		"textDocument/publishDiagnostics",
+		&PublishDiagnosticsParams{
+			URI:         uri,
+			Diagnostics: diagnostics,
+		})
 }
 
-func (h *langHandler) lint(uri string) []Diagnostic {
+func (h *langHandler) lint(ctx context.Context, uri string) []Diagnostic {
 	f, ok := h.files[uri]
 	if !ok {
 		fmt.Fprintf(os.Stderr, "document not found")
@@ -122,8 +217,37 @@ func (h *langHandler) lint(uri string) []Diagnostic {
 		fname = strings.ToLower(fname)
 	}
 
-	config := h.configFor(uri)
+	configs := h.configsFor(uri)
+	limit := h.workspaceFolderFor(fname)
+
+	var (
+		g           errgroup.Group
+		mu          sync.Mutex
+		diagnostics = []Diagnostic{}
+	)
+	for _, config := range configs {
+		config := config
+		g.Go(func() error {
+			ds := lintWith(ctx, config, f, fname, limit)
+			mu.Lock()
+			diagnostics = append(diagnostics, ds...)
+			mu.Unlock()
+			return nil
+		})
+	}
+	// Errors from individual linters are reported to stderr by lintWith and
+	// never cause the group to fail, so this only waits for completion.
+	_ = g.Wait()
+
+	return diagnostics
+}
 
+// lintWith runs a single lint command and parses its output into Diagnostics
+// for fname, tagging each with the command's configured severity and source.
+// If ctx is cancelled while the command is running, it is sent SIGTERM (or
+// killed outright on Windows, which has no equivalent signal) and lintWith
+// returns no diagnostics.
+func lintWith(ctx context.Context, config Config, f *File, fname string, limit string) []Diagnostic {
 	efms, err := errorformat.NewErrorformat(config.LintErrorFormats)
 	if err != nil {
 		fmt.Fprint(os.Stderr, err)
@@ -131,16 +255,24 @@ func (h *langHandler) lint(uri string) []Diagnostic {
 	}
 	diagnostics := []Diagnostic{}
 
+	root := findRootPath(fname, config.RootMarkers, limit)
+	command := substituteVars(config.LintCommand, root, fname)
+
 	var cmd *exec.Cmd
 	if runtime.GOOS == "windows" {
-		cmd = exec.Command("cmd", "/c", config.LintCommand)
+		cmd = exec.Command("cmd", "/c", command)
 	} else {
-		cmd = exec.Command("sh", "-c", config.LintCommand)
+		cmd = exec.Command("sh", "-c", command)
 	}
+	cmd.Dir = root
 	if config.LintStdin {
 		cmd.Stdin = strings.NewReader(f.Text)
 	}
-	b, err := cmd.CombinedOutput()
+
+	b, err := runCancelable(ctx, cmd)
+	if ctx.Err() != nil {
+		return nil
+	}
 	if err == nil {
 		fmt.Fprintf(os.Stderr, "succeeded: %q", f.Text)
 		return diagnostics
@@ -170,13 +302,21 @@ func (h *langHandler) lint(uri string) []Diagnostic {
 			if path != fname {
 				continue
 			}
+			severity := config.LintSeverity
+			if severity == 0 {
+				severity = 1
+			}
+			if cat, ok := config.LintCategoryMap[string(m.Type)]; ok {
+				severity = cat
+			}
 			diagnostics = append(diagnostics, Diagnostic{
 				Range: Range{
 					Start: Position{Line: m.L - 1 - config.LintOffset, Character: m.C - 1},
 					End:   Position{Line: m.L - 1 - config.LintOffset, Character: m.C - 1},
 				},
 				Message:  m.M,
-				Severity: 1,
+				Severity: severity,
+				Source:   config.LintSource,
 			})
 		}
 	}
@@ -184,22 +324,72 @@ func (h *langHandler) lint(uri string) []Diagnostic {
 	return diagnostics
 }
 
+// runCancelable starts cmd with its combined output captured into the
+// returned byte slice, and terminates it early if ctx is done before it
+// exits on its own.
+func runCancelable(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	var buf bytes.Buffer
+	cmd.Stdout = &buf
+	cmd.Stderr = &buf
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case <-ctx.Done():
+		terminate(cmd.Process)
+		<-done
+		return buf.Bytes(), ctx.Err()
+	case err := <-done:
+		return buf.Bytes(), err
+	}
+}
+
 func (h *langHandler) closeFile(uri string) error {
 	delete(h.files, uri)
 	return nil
 }
 
 func (h *langHandler) saveFile(uri string) error {
-	h.request <- uri
+	h.scheduleLint(uri)
 	return nil
 }
 
+// scheduleLint coalesces rapid successive lint requests for the same URI
+// into a single run, waiting out the URI's configured LintDebounce (or
+// lintDebounceDefault) so a burst of didChange notifications doesn't spawn a
+// lint process per keystroke.
+func (h *langHandler) scheduleLint(uri string) {
+	d := h.configFor(uri).LintDebounce
+	if d <= 0 {
+		d = lintDebounceDefault
+	}
+
+	h.debounceMu.Lock()
+	defer h.debounceMu.Unlock()
+
+	if t, ok := h.debounce[uri]; ok {
+		t.Stop()
+	}
+	h.debounce[uri] = time.AfterFunc(d, func() {
+		h.request <- uri
+	})
+}
+
 func (h *langHandler) openFile(uri string, languageId string) error {
 	f := &File{
 		Text:       "",
 		LanguageId: languageId,
 	}
 	h.files[uri] = f
+
+	if h.configFor(uri).LintAfterOpen {
+		h.scheduleLint(uri)
+	}
 	return nil
 }
 
@@ -210,7 +400,9 @@ func (h *langHandler) updateFile(uri string, text string) error {
 	}
 	f.Text = text
 
-	h.request <- uri
+	if !h.configFor(uri).LintOnSave {
+		h.scheduleLint(uri)
+	}
 	return nil
 }
 
@@ -228,15 +420,96 @@ func (h *langHandler) handle(ctx context.Context, conn *jsonrpc2.Conn, req *json
 		return h.handleTextDocumentDidSave(ctx, conn, req)
 	case "textDocument/didClose":
 		return h.handleTextDocumentDidClose(ctx, conn, req)
+	case "textDocument/formatting":
+		return h.handleTextDocumentFormatting(ctx, conn, req)
+	case "workspace/didChangeWorkspaceFolders":
+		return h.handleWorkspaceDidChangeWorkspaceFolders(ctx, conn, req)
 	}
 
 	return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeMethodNotFound, Message: fmt.Sprintf("method not supported: %s", req.Method)}
 }
 
-func (h *langHandler) configFor(uri string) Config {
+// configsFor returns every lint/format configuration registered for uri's
+// languageId, overlaid by the nearest .efm-langserver.yaml above the file,
+// if any.
+func (h *langHandler) configsFor(uri string) []Config {
 	f, ok := h.files[uri]
 	if !ok {
+		return nil
+	}
+	base := h.configs[f.LanguageId]
+
+	fname, err := fromURI(uri)
+	if err != nil {
+		return base
+	}
+	root, ok := findOverlayRoot(fname, h.workspaceFolderFor(fname))
+	if !ok {
+		return base
+	}
+	return h.overlays.loadOverlay(root).apply(f.LanguageId, base)
+}
+
+// setWorkspaceFolders replaces the set of workspace folders this handler
+// tracks, as reported by "initialize" or "workspace/didChangeWorkspaceFolders".
+func (h *langHandler) setWorkspaceFolders(folders []WorkspaceFolder) {
+	h.workspaceFoldersMu.Lock()
+	defer h.workspaceFoldersMu.Unlock()
+	h.workspaceFolders = folders
+}
+
+// normalizePath applies the case/separator normalization this package
+// already uses to compare filesystem paths derived from different sources
+// (see fname in lint() and path in lintWith): slashes are canonicalized and,
+// on Windows, the path is lowercased so that drive-letter and case
+// differences between a document URI and a workspace folder URI don't cause
+// a false mismatch.
+func normalizePath(path string) string {
+	path = filepath.ToSlash(path)
+	if runtime.GOOS == "windows" {
+		path = strings.ToLower(path)
+	}
+	return path
+}
+
+// workspaceFolderFor returns the local path of the most specific workspace
+// folder containing fname, so that root-marker and overlay lookups for a
+// file in a multi-root workspace never walk up into a sibling root. It
+// returns "" if fname isn't inside any known workspace folder (including
+// when the client never reported any), in which case callers impose no
+// bound. fname need not be pre-normalized; both it and each workspace
+// folder's path are normalized the same way here.
+func (h *langHandler) workspaceFolderFor(fname string) string {
+	h.workspaceFoldersMu.Lock()
+	folders := h.workspaceFolders
+	h.workspaceFoldersMu.Unlock()
+
+	fname = normalizePath(fname)
+
+	var best string
+	for _, folder := range folders {
+		dir, err := fromURI(folder.URI)
+		if err != nil {
+			continue
+		}
+		dir = normalizePath(dir)
+		if fname != dir && !strings.HasPrefix(fname, dir+"/") {
+			continue
+		}
+		if len(dir) > len(best) {
+			best = dir
+		}
+	}
+	return filepath.FromSlash(best)
+}
+
+// configFor returns the first configuration registered for uri's
+// languageId. Formatting uses it, and so do the lint triggers: LintDebounce,
+// LintAfterOpen and LintOnSave are read from this first configuration only.
+func (h *langHandler) configFor(uri string) Config {
+	configs := h.configsFor(uri)
+	if len(configs) == 0 {
 		return Config{}
 	}
-	return h.configs[f.LanguageId]
+	return configs[0]
 }
@@ -0,0 +1,167 @@
+package langserver
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/jsonrpc2"
+	"gopkg.in/yaml.v3"
+)
+
+// newTestHandler wires a langHandler up to a real jsonrpc2.Conn over an
+// in-memory pipe, so publishDiagnostics notifications can be observed on
+// the other end.
+func newTestHandler(t *testing.T, configs map[string]Configs) (*langHandler, <-chan string) {
+	t.Helper()
+
+	h := &langHandler{
+		configs:  configs,
+		files:    make(map[string]*File),
+		request:  make(chan string),
+		debounce: make(map[string]*time.Timer),
+		jobs:     make(map[string]*lintJob),
+		overlays: newOverlayCache(),
+	}
+	go h.linter()
+
+	server, client := net.Pipe()
+	notifications := make(chan string, 16)
+	clientHandler := jsonrpc2.HandlerWithError(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+		notifications <- req.Method
+		return nil, nil
+	})
+	jsonrpc2.NewConn(context.Background(), jsonrpc2.NewBufferedStream(client, jsonrpc2.VSCodeObjectCodec{}), clientHandler)
+	h.conn = jsonrpc2.NewConn(context.Background(), jsonrpc2.NewBufferedStream(server, jsonrpc2.VSCodeObjectCodec{}), jsonrpc2.HandlerWithError(h.handle))
+
+	t.Cleanup(func() { h.conn.Close() })
+
+	return h, notifications
+}
+
+func TestUpdateFileDebouncesRapidChanges(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("relies on a POSIX shell")
+	}
+	configs := map[string]Configs{
+		"sh": {{LintCommand: "true", LintDebounce: 20 * time.Millisecond}},
+	}
+	h, notifications := newTestHandler(t, configs)
+
+	uri := "file:///tmp/efm-test-debounce.sh"
+	if err := h.openFile(uri, "sh"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := h.updateFile(uri, "echo hi"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case <-notifications:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected a publishDiagnostics notification after the quiescent period")
+	}
+
+	select {
+	case m := <-notifications:
+		t.Fatalf("expected only one notification for the burst of changes, got a second: %s", m)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestRunLintCancelsInFlightJob(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("relies on a POSIX shell")
+	}
+	configs := map[string]Configs{
+		"sh": {{LintCommand: "sleep 1", LintDebounce: time.Millisecond}},
+	}
+	h, notifications := newTestHandler(t, configs)
+
+	uri := "file:///tmp/efm-test-cancel.sh"
+	if err := h.openFile(uri, "sh"); err != nil {
+		t.Fatal(err)
+	}
+
+	h.request <- uri
+	time.Sleep(100 * time.Millisecond) // let the slow job start and register itself
+
+	h.jobsMu.Lock()
+	_, running := h.jobs[uri]
+	h.jobsMu.Unlock()
+	if !running {
+		t.Fatal("expected the first lint job to be registered as in-flight")
+	}
+
+	// Swap in a fast command before triggering the second job, so its
+	// notification arrives well before the first job's "sleep 1" would end.
+	// Any further notification in the window after that could only come
+	// from the first job, had it not been cancelled.
+	configs["sh"][0].LintCommand = "true"
+	h.request <- uri
+
+	select {
+	case <-notifications:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected a publishDiagnostics notification from the second job")
+	}
+
+	select {
+	case m := <-notifications:
+		t.Fatalf("the cancelled first job should not have published diagnostics, got %s", m)
+	case <-time.After(1200 * time.Millisecond):
+	}
+}
+
+func TestWorkspaceFolderFor(t *testing.T) {
+	base := t.TempDir()
+	foo := filepath.Join(base, "foo")
+	bar := filepath.Join(base, "bar")
+	for _, dir := range []string{foo, bar} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := &langHandler{}
+	h.setWorkspaceFolders([]WorkspaceFolder{
+		{URI: toURI(foo).String()},
+		{URI: toURI(bar).String()},
+	})
+
+	if got := h.workspaceFolderFor(filepath.Join(foo, "a.go")); got != foo {
+		t.Errorf("workspaceFolderFor() = %q, want %q", got, foo)
+	}
+	if got := h.workspaceFolderFor(filepath.Join(bar, "b.go")); got != bar {
+		t.Errorf("workspaceFolderFor() = %q, want %q", got, bar)
+	}
+	if got := h.workspaceFolderFor(filepath.Join(base, "baz", "c.go")); got != "" {
+		t.Errorf("workspaceFolderFor() outside any folder = %q, want %q", got, "")
+	}
+}
+
+func TestConfigsUnmarshalYAML(t *testing.T) {
+	var cs Configs
+
+	single := []byte("lint-command: golint\nlint-stdin: true\n")
+	if err := yaml.Unmarshal(single, &cs); err != nil {
+		t.Fatalf("unmarshalling a single mapping: %v", err)
+	}
+	if len(cs) != 1 || cs[0].LintCommand != "golint" || !cs[0].LintStdin {
+		t.Fatalf("unexpected result for single mapping: %+v", cs)
+	}
+
+	list := []byte("- lint-command: eslint\n- lint-command: tsc\n  lint-stdin: false\n")
+	if err := yaml.Unmarshal(list, &cs); err != nil {
+		t.Fatalf("unmarshalling a sequence: %v", err)
+	}
+	if len(cs) != 2 || cs[0].LintCommand != "eslint" || cs[1].LintCommand != "tsc" {
+		t.Fatalf("unexpected result for sequence: %+v", cs)
+	}
+}
@@ -0,0 +1,64 @@
+package langserver
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// overlayFile is the name of a per-project config file that overlays the
+// global configuration for files rooted under the directory it lives in.
+const overlayFile = ".efm-langserver.yaml"
+
+// overlayConfig is the subset of the global config schema that a project may
+// override on a per-languageId basis.
+type overlayConfig struct {
+	Languages map[string]Configs `yaml:"languages"`
+}
+
+// apply overlays languageId's configuration on top of base, if the overlay
+// configures that languageId at all.
+func (ov *overlayConfig) apply(languageId string, base []Config) []Config {
+	if ov == nil {
+		return base
+	}
+	if cs, ok := ov.Languages[languageId]; ok {
+		return cs
+	}
+	return base
+}
+
+// overlayCache parses and caches .efm-langserver.yaml files keyed by the
+// root directory they were discovered in, so repeated lookups for files
+// under the same root don't re-read and re-parse the overlay each time.
+type overlayCache struct {
+	mu     sync.Mutex
+	byRoot map[string]*overlayConfig
+}
+
+func newOverlayCache() *overlayCache {
+	return &overlayCache{byRoot: make(map[string]*overlayConfig)}
+}
+
+// loadOverlay returns the overlay for root, reading and parsing it on first
+// use and caching the result (including a not-found or unparsable file, as
+// an empty overlay) for subsequent calls.
+func (c *overlayCache) loadOverlay(root string) *overlayConfig {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ov, ok := c.byRoot[root]; ok {
+		return ov
+	}
+
+	ov := &overlayConfig{}
+	if b, err := os.ReadFile(filepath.Join(root, overlayFile)); err == nil {
+		if err := yaml.Unmarshal(b, ov); err != nil {
+			ov = &overlayConfig{}
+		}
+	}
+	c.byRoot[root] = ov
+	return ov
+}
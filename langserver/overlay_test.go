@@ -0,0 +1,57 @@
+package langserver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOverlayCacheLoadOverlay(t *testing.T) {
+	base := t.TempDir()
+	overlay := "languages:\n  go:\n    - lint-command: custom-lint\n"
+	if err := os.WriteFile(filepath.Join(base, overlayFile), []byte(overlay), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newOverlayCache()
+	ov := c.loadOverlay(base)
+	if cs, ok := ov.Languages["go"]; !ok || len(cs) != 1 || cs[0].LintCommand != "custom-lint" {
+		t.Fatalf("loadOverlay() = %+v, want a go overlay with lint-command custom-lint", ov)
+	}
+
+	// A second load for the same root must hit the cache rather than
+	// re-reading the file: removing it shouldn't change the result.
+	if err := os.Remove(filepath.Join(base, overlayFile)); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.loadOverlay(base); got != ov {
+		t.Errorf("loadOverlay() on a cached root = %+v, want the cached %+v", got, ov)
+	}
+}
+
+func TestOverlayCacheLoadOverlayMissing(t *testing.T) {
+	c := newOverlayCache()
+	ov := c.loadOverlay(t.TempDir())
+	if len(ov.Languages) != 0 {
+		t.Fatalf("loadOverlay() with no overlay file = %+v, want an empty overlay", ov)
+	}
+}
+
+func TestOverlayConfigApply(t *testing.T) {
+	base := []Config{{LintCommand: "default-lint"}}
+
+	var nilOverlay *overlayConfig
+	if got := nilOverlay.apply("go", base); len(got) != 1 || got[0].LintCommand != "default-lint" {
+		t.Errorf("(*overlayConfig)(nil).apply() = %+v, want base unchanged", got)
+	}
+
+	ov := &overlayConfig{Languages: map[string]Configs{
+		"go": {{LintCommand: "custom-lint"}},
+	}}
+	if got := ov.apply("go", base); len(got) != 1 || got[0].LintCommand != "custom-lint" {
+		t.Errorf("apply() for an overlaid language = %+v, want custom-lint", got)
+	}
+	if got := ov.apply("python", base); len(got) != 1 || got[0].LintCommand != "default-lint" {
+		t.Errorf("apply() for a language without an overlay = %+v, want base unchanged", got)
+	}
+}
@@ -0,0 +1,62 @@
+package langserver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// findRootPath walks upward from the directory containing fname looking for
+// a directory containing one of markers, and returns it. The walk never
+// goes above limit (the owning workspace folder, if any; pass "" for no
+// bound). If no marker is found, it falls back to fname's own directory.
+func findRootPath(fname string, markers []string, limit string) string {
+	dir := filepath.Dir(fname)
+	if len(markers) == 0 {
+		return dir
+	}
+
+	for {
+		for _, marker := range markers {
+			if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+				return dir
+			}
+		}
+		if limit != "" && dir == limit {
+			return filepath.Dir(fname)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return filepath.Dir(fname)
+		}
+		dir = parent
+	}
+}
+
+// substituteVars expands ${ROOT} and ${INPUT} in command to root and fname
+// respectively.
+func substituteVars(command, root, fname string) string {
+	command = strings.ReplaceAll(command, "${ROOT}", root)
+	command = strings.ReplaceAll(command, "${INPUT}", fname)
+	return command
+}
+
+// findOverlayRoot walks upward from the directory containing fname looking
+// for a directory containing overlayFile, returning it if found. Like
+// findRootPath, the walk never goes above limit.
+func findOverlayRoot(fname string, limit string) (string, bool) {
+	dir := filepath.Dir(fname)
+	for {
+		if _, err := os.Stat(filepath.Join(dir, overlayFile)); err == nil {
+			return dir, true
+		}
+		if limit != "" && dir == limit {
+			return "", false
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
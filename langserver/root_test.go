@@ -0,0 +1,59 @@
+package langserver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindRootPath(t *testing.T) {
+	base := t.TempDir()
+	if err := os.WriteFile(filepath.Join(base, "go.mod"), []byte("module example\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sub := filepath.Join(base, "pkg", "inner")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	fname := filepath.Join(sub, "file.go")
+
+	if got := findRootPath(fname, []string{"go.mod"}, ""); got != base {
+		t.Errorf("findRootPath() = %q, want %q", got, base)
+	}
+
+	if got := findRootPath(fname, nil, ""); got != sub {
+		t.Errorf("findRootPath() with no markers = %q, want %q", got, sub)
+	}
+
+	if got := findRootPath(fname, []string{"go.mod"}, sub); got != sub {
+		t.Errorf("findRootPath() bounded by limit = %q, want %q (the limit itself)", got, sub)
+	}
+}
+
+func TestFindOverlayRoot(t *testing.T) {
+	base := t.TempDir()
+	if err := os.WriteFile(filepath.Join(base, overlayFile), []byte("languages:\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sub := filepath.Join(base, "pkg", "inner")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	fname := filepath.Join(sub, "file.go")
+
+	if root, ok := findOverlayRoot(fname, ""); !ok || root != base {
+		t.Errorf("findOverlayRoot() = (%q, %v), want (%q, true)", root, ok, base)
+	}
+
+	if root, ok := findOverlayRoot(fname, sub); ok {
+		t.Errorf("findOverlayRoot() bounded by limit = (%q, %v), want (\"\", false)", root, ok)
+	}
+}
+
+func TestSubstituteVars(t *testing.T) {
+	got := substituteVars("cd ${ROOT} && lint ${INPUT}", "/proj", "/proj/a.go")
+	want := "cd /proj && lint /proj/a.go"
+	if got != want {
+		t.Errorf("substituteVars() = %q, want %q", got, want)
+	}
+}